@@ -0,0 +1,218 @@
+/*
+Copyright (c) 2016, UPMC Enterprises
+All rights reserved.
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright
+      notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright
+      notice, this list of conditions and the following disclaimer in the
+      documentation and/or other materials provided with the distribution.
+    * Neither the name UPMC Enterprises nor the
+      names of its contributors may be used to endorse or promote products
+      derived from this software without specific prior written permission.
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL UPMC ENTERPRISES BE LIABLE FOR ANY
+DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	argACRSecretName = flags.String("acr-secret-name", "acr-secret", `Default acr secret name`)
+	argACRURL        = flags.String("acr-url", "", `Default ACR URL, e.g. myregistry.azurecr.io`)
+	argACRRegistries = flags.StringSlice("acr-registries", []string{}, `Azure Container Registries to generate credentials for, e.g. myregistry.azurecr.io,otherregistry.azurecr.io`)
+)
+
+// acrInterface obtains an AAD access token and exchanges it for an ACR
+// refresh token that can be used as a docker password against a given
+// registry.
+type acrInterface interface {
+	GetRefreshToken(registry string) (string, error)
+}
+
+const (
+	azureManagementScope = "https://management.azure.com/"
+	imdsTokenURL         = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+type acrClient struct {
+	httpClient *http.Client
+}
+
+func newAcrClient() acrInterface {
+	return &acrClient{httpClient: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// getAADAccessToken obtains an Azure AD access token for the ARM resource,
+// preferring service-principal client-secret auth (AZURE_TENANT_ID /
+// AZURE_CLIENT_ID / AZURE_CLIENT_SECRET) and falling back to the node's
+// Managed Identity via IMDS.
+func (a *acrClient) getAADAccessToken() (string, error) {
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	clientSecret := os.Getenv("AZURE_CLIENT_SECRET")
+
+	if tenantID != "" && clientID != "" && clientSecret != "" {
+		return a.getAADAccessTokenClientSecret(tenantID, clientID, clientSecret)
+	}
+
+	return a.getAADAccessTokenManagedIdentity()
+}
+
+func (a *acrClient) getAADAccessTokenClientSecret(tenantID, clientID, clientSecret string) (string, error) {
+	tokenURL := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/token", tenantID)
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", clientID)
+	form.Set("client_secret", clientSecret)
+	form.Set("resource", azureManagementScope)
+
+	resp, err := a.httpClient.PostForm(tokenURL, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("aad token request failed with status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	return body.AccessToken, nil
+}
+
+func (a *acrClient) getAADAccessTokenManagedIdentity() (string, error) {
+	req, err := http.NewRequest("GET", imdsTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	q := req.URL.Query()
+	q.Set("api-version", "2018-02-01")
+	q.Set("resource", azureManagementScope)
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("imds token request failed with status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	return body.AccessToken, nil
+}
+
+// GetRefreshToken obtains an AAD access token and exchanges it for an ACR
+// refresh token scoped to the given registry, per
+// https://<registry>/oauth2/exchange.
+func (a *acrClient) GetRefreshToken(registry string) (string, error) {
+	aadAccessToken, err := a.getAADAccessToken()
+	if err != nil {
+		return "", err
+	}
+
+	exchangeURL := fmt.Sprintf("https://%s/oauth2/exchange", registry)
+
+	form := url.Values{}
+	form.Set("grant_type", "access_token")
+	form.Set("service", registry)
+	form.Set("access_token", aadAccessToken)
+
+	resp, err := a.httpClient.PostForm(exchangeURL, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("acr token exchange failed with status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	return body.RefreshToken, nil
+}
+
+// acrRegistries returns the set of ACR registries to generate secrets for,
+// falling back to --acr-url if --acr-registries wasn't given.
+func acrRegistries() []string {
+	if len(*argACRRegistries) > 0 {
+		return *argACRRegistries
+	}
+	if *argACRURL != "" {
+		return []string{*argACRURL}
+	}
+	return nil
+}
+
+// acrSecretName derives a secret name for a given registry, so multiple
+// registries passed via --acr-registries don't collide on one Secret. The
+// full registry host is used (dots replaced with dashes, since Secret names
+// must be valid DNS subdomains) rather than just its first label, so two
+// registries sharing a prefix (foo.azurecr.io vs foo.westus.azurecr.io)
+// still get distinct names.
+func acrSecretName(registry string) string {
+	if len(*argACRRegistries) <= 1 {
+		return *argACRSecretName
+	}
+	host := strings.Replace(registry, ".", "-", -1)
+	return fmt.Sprintf("%s-%s", *argACRSecretName, host)
+}
+
+// acrRefreshTokenUsername is the fixed username ACR expects when the
+// password is a refresh token rather than an AAD access token directly; see
+// https://aka.ms/acr/authentication.
+const acrRefreshTokenUsername = "00000000-0000-0000-0000-000000000000"
+
+func (c *controller) getACRAuthorizationKey(registry string) (AuthToken, error) {
+	refreshToken, err := c.acrClient.GetRefreshToken(registry)
+	if err != nil {
+		return AuthToken{}, err
+	}
+
+	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", acrRefreshTokenUsername, refreshToken)))
+
+	return AuthToken{
+		AccessToken: auth,
+		Endpoint:    registry}, nil
+}
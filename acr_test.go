@@ -0,0 +1,59 @@
+/*
+Copyright (c) 2016, UPMC Enterprises
+All rights reserved.
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright
+      notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright
+      notice, this list of conditions and the following disclaimer in the
+      documentation and/or other materials provided with the distribution.
+    * Neither the name UPMC Enterprises nor the
+      names of its contributors may be used to endorse or promote products
+      derived from this software without specific prior written permission.
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL UPMC ENTERPRISES BE LIABLE FOR ANY
+DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+*/
+
+package main
+
+import "testing"
+
+func TestAcrSecretNameSingleRegistry(t *testing.T) {
+	*argACRSecretName = "acr-secret"
+	*argACRRegistries = []string{}
+
+	got := acrSecretName("myregistry.azurecr.io")
+	want := "acr-secret"
+	if got != want {
+		t.Errorf("acrSecretName() = %q, want %q", got, want)
+	}
+}
+
+func TestAcrSecretNameMultipleRegistries(t *testing.T) {
+	*argACRSecretName = "acr-secret"
+	*argACRRegistries = []string{"foo.azurecr.io", "foo.westus.azurecr.io"}
+
+	got := acrSecretName("foo.azurecr.io")
+	want := "acr-secret-foo-azurecr-io"
+	if got != want {
+		t.Errorf("acrSecretName(%q) = %q, want %q", "foo.azurecr.io", got, want)
+	}
+
+	got = acrSecretName("foo.westus.azurecr.io")
+	want = "acr-secret-foo-westus-azurecr-io"
+	if got != want {
+		t.Errorf("acrSecretName(%q) = %q, want %q", "foo.westus.azurecr.io", got, want)
+	}
+
+	if acrSecretName("foo.azurecr.io") == acrSecretName("foo.westus.azurecr.io") {
+		t.Error("acrSecretName() collided for registries sharing a prefix")
+	}
+}
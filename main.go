@@ -0,0 +1,536 @@
+/*
+Copyright (c) 2016, UPMC Enterprises
+All rights reserved.
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright
+      notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright
+      notice, this list of conditions and the following disclaimer in the
+      documentation and/or other materials provided with the distribution.
+    * Neither the name UPMC Enterprises nor the
+      names of its contributors may be used to endorse or promote products
+      derived from this software without specific prior written permission.
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL UPMC ENTERPRISES BE LIABLE FOR ANY
+DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	flag "github.com/spf13/pflag"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/pkg/client/restclient"
+	"k8s.io/kubernetes/pkg/client/unversioned"
+	kubectl_util "k8s.io/kubernetes/pkg/kubectl/cmd/util"
+)
+
+const (
+	dockerCfgTemplate  = `{"%s":{"username":"oauth2accesstoken","password":"%s","email":"none"}}`
+	dockerJSONTemplate = `{"auths":{"%s":{"auth":"%s","email":"none"}}}`
+)
+
+var (
+	flags               = flag.NewFlagSet("", flag.ContinueOnError)
+	cluster             = flags.Bool("use-kubernetes-cluster-service", true, `If true, use the built in kubernetes cluster for creating the client`)
+	argKubecfgFile      = flags.String("kubecfg-file", "", `Location of kubecfg file for access to kubernetes master service; --kube_master_url overrides the URL part of this; if neither this nor --kube_master_url are provided, defaults to service account tokens`)
+	argKubeMasterURL    = flags.String("kube-master-url", "", `URL to reach kubernetes master. Env variables in this flag will be expanded.`)
+	argAWSSecretName    = flags.String("aws-secret-name", "awsecr-cred", `Default aws secret name`)
+	argGCRSecretName    = flags.String("gcr-secret-name", "gcr-secret", `Default gcr secret name`)
+	argDefaultNamespace = flags.String("default-namespace", "default", `Default namespace`)
+	argGCRURL           = flags.String("gcr-url", "https://gcr.io", `Default GCR URL`)
+	argAWSRegion        = flags.String("aws-region", "us-east-1", `Default AWS region`)
+	argRefreshMinutes   = flags.Int("refresh-mins", 60, `Default time to wait before refreshing (60 minutes)`)
+)
+
+var (
+	awsAccountID string
+)
+
+type controller struct {
+	kubeClient kubeInterface
+	ecrClient  ecrInterface
+	gcrClient  gcrInterface
+	acrClient  acrInterface
+
+	// tokenCacheMu guards tokenCache, the most recently minted AuthToken
+	// per SecretGenerator (keyed by SecretName). Namespace seeding reuses
+	// these instead of minting a fresh token, so a new namespace doesn't
+	// have to wait on a provider's refresh cycle.
+	tokenCacheMu sync.Mutex
+	tokenCache   map[string]AuthToken
+
+	// eventRecorder records Kubernetes Events against the Secrets and
+	// ServiceAccounts this controller touches, so `kubectl describe` shows
+	// rotation history. Nil is safe to use (see recordEvent).
+	eventRecorder record.EventRecorder
+
+	vaultProviderOnce sync.Once
+	vaultProviderInst *vaultProvider
+}
+
+type kubeInterface interface {
+	Secrets(namespace string) unversioned.SecretsInterface
+	Namespaces() unversioned.NamespaceInterface
+	ServiceAccounts(namespace string) unversioned.ServiceAccountsInterface
+	Events(namespace string) unversioned.EventInterface
+}
+
+type ecrInterface interface {
+	GetAuthorizationToken(input *ecr.GetAuthorizationTokenInput) (*ecr.GetAuthorizationTokenOutput, error)
+}
+
+type gcrInterface interface {
+	DefaultTokenSource(ctx context.Context, scope ...string) (oauth2.TokenSource, error)
+}
+
+func newEcrClient() ecrInterface {
+	return ecr.New(session.New(), aws.NewConfig().WithRegion(*argAWSRegion))
+}
+
+type gcrClient struct{}
+
+func (gcr gcrClient) DefaultTokenSource(ctx context.Context, scope ...string) (oauth2.TokenSource, error) {
+	return google.DefaultTokenSource(ctx, scope...)
+}
+
+func newGcrClient() gcrInterface {
+	return gcrClient{}
+}
+
+func newKubeClient() kubeInterface {
+	var kubeClient *unversioned.Client
+	var config *restclient.Config
+	var err error
+
+	clientConfig := kubectl_util.DefaultClientConfig(flags)
+
+	if *cluster {
+		if kubeClient, err = unversioned.NewInCluster(); err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+	} else {
+		config, err = clientConfig.ClientConfig()
+		if err != nil {
+			log.Fatalf("error connecting to the client: %v", err)
+		}
+		kubeClient, err = unversioned.New(config)
+
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+	}
+
+	return kubeClient
+}
+
+func (c *controller) getGCRAuthorizationKey() (AuthToken, error) {
+	ts, err := c.gcrClient.DefaultTokenSource(context.TODO(), "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		log.Print("getting creds was nil")
+		return AuthToken{}, err
+	}
+
+	token, err := ts.Token()
+	if err != nil {
+		return AuthToken{}, err
+	}
+
+	if !token.Valid() {
+		return AuthToken{}, fmt.Errorf("token was invalid")
+	}
+
+	if token.Type() != "Bearer" {
+		return AuthToken{}, fmt.Errorf(fmt.Sprintf("expected token type \"Bearer\" but got \"%s\"", token.Type()))
+	}
+
+	return AuthToken{
+		AccessToken: token.AccessToken,
+		Endpoint:    *argGCRURL,
+		ExpiresAt:   token.Expiry}, nil
+}
+
+func (c *controller) getECRAuthorizationKey() (AuthToken, error) {
+	params := &ecr.GetAuthorizationTokenInput{
+		RegistryIds: []*string{
+			aws.String(awsAccountID),
+		},
+	}
+
+	resp, err := c.ecrClient.GetAuthorizationToken(params)
+
+	if err != nil {
+		// Print the error, cast err to awserr.Error to get the Code and
+		// Message from an error.
+		fmt.Println(err.Error())
+		return AuthToken{}, err
+	}
+
+	token := resp.AuthorizationData[0]
+
+	return AuthToken{
+		AccessToken: *token.AuthorizationToken,
+		Endpoint:    *token.ProxyEndpoint,
+		ExpiresAt:   *token.ExpiresAt}, err
+}
+
+func generateSecretObj(token string, endpoint string, isJSONCfg bool, secretName string) *api.Secret {
+	secret := &api.Secret{
+		ObjectMeta: api.ObjectMeta{
+			Name: secretName,
+		},
+	}
+	if isJSONCfg {
+		secret.Data = map[string][]byte{
+			".dockerconfigjson": []byte(fmt.Sprintf(dockerJSONTemplate, endpoint, token))}
+		secret.Type = "kubernetes.io/dockerconfigjson"
+	} else {
+		secret.Data = map[string][]byte{
+			".dockercfg": []byte(fmt.Sprintf(dockerCfgTemplate, endpoint, token))}
+		secret.Type = "kubernetes.io/dockercfg"
+	}
+	return secret
+}
+
+type AuthToken struct {
+	AccessToken string
+	Endpoint    string
+	// ExpiresAt is the time the token stops being valid, when known. Zero
+	// when a provider doesn't report an expiry, in which case refreshes
+	// fall back to --refresh-mins.
+	ExpiresAt time.Time
+}
+
+// SecretFormat is the shape of docker credentials a Provider produces.
+type SecretFormat int
+
+const (
+	// SecretFormatDockerCfg is the legacy kubernetes.io/dockercfg format.
+	SecretFormatDockerCfg SecretFormat = iota
+	// SecretFormatDockerConfigJSON is the kubernetes.io/dockerconfigjson format.
+	SecretFormatDockerConfigJSON
+)
+
+// Provider is a source of registry credentials. Registering one in
+// controller.providers() is all a new registry type needs to be synced as a
+// pull secret; nothing else in process()/applySecret changes.
+type Provider interface {
+	Name() string
+	Fetch(ctx context.Context) (AuthToken, error)
+	SecretType() SecretFormat
+}
+
+// providerFunc adapts a plain fetch function to the Provider interface, so
+// the existing getGCRAuthorizationKey/getECRAuthorizationKey/
+// getACRAuthorizationKey methods don't need their own named types.
+type providerFunc struct {
+	name       string
+	secretType SecretFormat
+	fetch      func(ctx context.Context) (AuthToken, error)
+}
+
+func (p providerFunc) Name() string                                 { return p.name }
+func (p providerFunc) SecretType() SecretFormat                     { return p.secretType }
+func (p providerFunc) Fetch(ctx context.Context) (AuthToken, error) { return p.fetch(ctx) }
+
+// providers returns every configured credential Provider, keyed by
+// SecretName: GCR and ECR are always present, one ACR provider per registry
+// in acrRegistries(), and a vaultProvider when --vault-addr is set.
+func (c *controller) providers() map[string]Provider {
+	providers := map[string]Provider{}
+
+	register := func(p Provider) {
+		if _, exists := providers[p.Name()]; exists {
+			log.Printf("Provider secret name %q is registered more than once; only the last registration will be synced", p.Name())
+		}
+		providers[p.Name()] = p
+	}
+
+	register(providerFunc{
+		name:       *argGCRSecretName,
+		secretType: SecretFormatDockerCfg,
+		fetch:      func(ctx context.Context) (AuthToken, error) { return c.getGCRAuthorizationKey() },
+	})
+	register(providerFunc{
+		name:       *argAWSSecretName,
+		secretType: SecretFormatDockerConfigJSON,
+		fetch:      func(ctx context.Context) (AuthToken, error) { return c.getECRAuthorizationKey() },
+	})
+
+	for _, registry := range acrRegistries() {
+		registry := registry
+		register(providerFunc{
+			name:       acrSecretName(registry),
+			secretType: SecretFormatDockerConfigJSON,
+			fetch:      func(ctx context.Context) (AuthToken, error) { return c.getACRAuthorizationKey(registry) },
+		})
+	}
+
+	if *argVaultAddr != "" {
+		register(c.vaultProvider())
+	}
+
+	return providers
+}
+
+type SecretGenerator struct {
+	TokenGenFxn func() (AuthToken, error)
+	IsJSONCfg   bool
+	SecretName  string
+}
+
+// secretGenerators adapts providers() to the SecretGenerator shape that
+// applySecret/refreshLoop/watchNamespaces already know how to sync.
+func (c *controller) secretGenerators() []SecretGenerator {
+	providers := c.providers()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	secretGenerators := make([]SecretGenerator, 0, len(names))
+	for _, name := range names {
+		p := providers[name]
+		secretGenerators = append(secretGenerators, SecretGenerator{
+			TokenGenFxn: func() (AuthToken, error) { return p.Fetch(context.Background()) },
+			IsJSONCfg:   p.SecretType() == SecretFormatDockerConfigJSON,
+			SecretName:  p.Name(),
+		})
+	}
+
+	return secretGenerators
+}
+
+// applySecret writes newToken into secretGenerator's Secret (creating or
+// updating as needed) in every namespace matching --namespace-label-selector
+// and not in --excluded-namespaces, and makes sure each namespace's default
+// ServiceAccount references it as an image pull secret. It also caches
+// newToken so a namespace that appears later can be seeded immediately,
+// without waiting on secretGenerator's own refresh cycle.
+func (c *controller) applySecret(secretGenerator SecretGenerator, newToken AuthToken) error {
+	c.tokenCacheMu.Lock()
+	c.tokenCache[secretGenerator.SecretName] = newToken
+	c.tokenCacheMu.Unlock()
+
+	namespaces, err := c.kubeClient.Namespaces().List(namespaceListOptions())
+	if err != nil {
+		return err
+	}
+
+	for _, namespace := range namespaces.Items {
+		if isExcludedNamespace(namespace.GetName()) {
+			continue
+		}
+
+		if err := c.applySecretToNamespace(secretGenerator, newToken, namespace.GetName()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applySecretToNamespace writes newToken into secretGenerator's Secret in a
+// single namespace, creating or updating as needed, and makes sure that
+// namespace's default ServiceAccount references it as an image pull secret.
+func (c *controller) applySecretToNamespace(secretGenerator SecretGenerator, newToken AuthToken, namespace string) error {
+	newSecret := generateSecretObj(newToken.AccessToken, newToken.Endpoint, secretGenerator.IsJSONCfg, secretGenerator.SecretName)
+	secretRef := api.ObjectReference{Kind: "Secret", Namespace: namespace, Name: secretGenerator.SecretName}
+
+	// Check if the secret exists for the namespace
+	_, err := c.kubeClient.Secrets(namespace).Get(secretGenerator.SecretName)
+
+	if err != nil {
+		// Secret not found, create
+		_, err := c.kubeClient.Secrets(namespace).Create(newSecret)
+		if err != nil {
+			c.recordEvent(secretRef, api.EventTypeWarning, "SecretSyncFailed", fmt.Sprintf("Failed to create pull secret: %v", err))
+			return err
+		}
+		secretSyncTotal.WithLabelValues(secretGenerator.SecretName, namespace, "create").Inc()
+		c.recordEvent(secretRef, api.EventTypeNormal, "SecretCreated", "Created image pull secret")
+	} else {
+		// Existing secret needs updated
+		_, err := c.kubeClient.Secrets(namespace).Update(newSecret)
+		if err != nil {
+			c.recordEvent(secretRef, api.EventTypeWarning, "SecretSyncFailed", fmt.Sprintf("Failed to update pull secret: %v", err))
+			return err
+		}
+		secretSyncTotal.WithLabelValues(secretGenerator.SecretName, namespace, "update").Inc()
+		c.recordEvent(secretRef, api.EventTypeNormal, "SecretUpdated", "Rotated image pull secret")
+	}
+
+	// Check if ServiceAccount exists
+	serviceAccount, err := c.kubeClient.ServiceAccounts(namespace).Get("default")
+
+	if err != nil {
+		return err
+	}
+
+	// Update existing one if image pull secrets already exists for aws ecr token
+	imagePullSecretFound := false
+	for i, imagePullSecret := range serviceAccount.ImagePullSecrets {
+		if imagePullSecret.Name == secretGenerator.SecretName {
+			serviceAccount.ImagePullSecrets[i] = api.LocalObjectReference{Name: secretGenerator.SecretName}
+			imagePullSecretFound = true
+			break
+		}
+	}
+
+	// Append to list of existing service accounts if there isn't one already
+	if !imagePullSecretFound {
+		serviceAccount.ImagePullSecrets = append(serviceAccount.ImagePullSecrets, api.LocalObjectReference{Name: secretGenerator.SecretName})
+	}
+
+	_, err = c.kubeClient.ServiceAccounts(namespace).Update(serviceAccount)
+	if err != nil {
+		c.recordEvent(api.ObjectReference{Kind: "ServiceAccount", Namespace: namespace, Name: "default"}, api.EventTypeWarning, "SecretSyncFailed", fmt.Sprintf("Failed to attach pull secret %s: %v", secretGenerator.SecretName, err))
+		return err
+	}
+
+	return nil
+}
+
+const (
+	// refreshLifetimeFraction is how much of a token's remaining lifetime
+	// to let elapse before refreshing it again.
+	refreshLifetimeFraction = 0.75
+	// refreshJitterFraction is the +/- jitter applied to the computed
+	// refresh delay, so providers sharing an expiry don't all refresh in
+	// lockstep.
+	refreshJitterFraction = 0.10
+
+	backoffInitial = 5 * time.Second
+	backoffMax     = 5 * time.Minute
+)
+
+// nextRefreshDelay schedules the next refresh at refreshLifetimeFraction of
+// a token's remaining lifetime, +/- refreshJitterFraction jitter. Providers
+// that don't report an ExpiresAt fall back to --refresh-mins.
+func nextRefreshDelay(expiresAt time.Time) time.Duration {
+	if expiresAt.IsZero() {
+		return time.Duration(*argRefreshMinutes) * time.Minute
+	}
+
+	remaining := time.Until(expiresAt)
+	if remaining <= 0 {
+		return 0
+	}
+
+	delay := time.Duration(float64(remaining) * refreshLifetimeFraction)
+	jitter := time.Duration((rand.Float64()*2 - 1) * refreshJitterFraction * float64(delay))
+	delay += jitter
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
+
+// refreshLoop refreshes secretGenerator's token and re-applies it forever,
+// scheduling each refresh off the token's own expiry (see nextRefreshDelay)
+// instead of a fixed tick, and backing off exponentially on error instead of
+// crashing the process.
+func (c *controller) refreshLoop(secretGenerator SecretGenerator) {
+	backoff := backoffInitial
+
+	for {
+		start := time.Now()
+		token, err := secretGenerator.TokenGenFxn()
+		if err == nil {
+			err = c.applySecret(secretGenerator, token)
+		}
+		refreshLatencySeconds.WithLabelValues(secretGenerator.SecretName).Observe(time.Since(start).Seconds())
+
+		if err != nil {
+			tokenRefreshTotal.WithLabelValues(secretGenerator.SecretName, "error").Inc()
+			log.Printf("Error refreshing %s, retrying in %s: %v", secretGenerator.SecretName, backoff, err)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > backoffMax {
+				backoff = backoffMax
+			}
+			continue
+		}
+
+		tokenRefreshTotal.WithLabelValues(secretGenerator.SecretName, "success").Inc()
+		if !token.ExpiresAt.IsZero() {
+			tokenExpirySeconds.WithLabelValues(secretGenerator.SecretName).Set(float64(token.ExpiresAt.Unix()))
+		}
+
+		backoff = backoffInitial
+		delay := nextRefreshDelay(token.ExpiresAt)
+		log.Printf("Finished processing secret for: %s, next refresh in %s", secretGenerator.SecretName, delay)
+		time.Sleep(delay)
+	}
+}
+
+func validateParams() {
+	awsAccountID = os.Getenv("awsaccount")
+	if len(awsAccountID) == 0 {
+		log.Print("Missing awsaccount env variable, assuming GCR usage")
+	}
+
+	awsRegionEnv := os.Getenv("awsregion")
+
+	if len(awsRegionEnv) > 0 {
+		argAWSRegion = &awsRegionEnv
+	}
+}
+
+func main() {
+	log.Print("Starting up...")
+	flags.Parse(os.Args)
+
+	validateParams()
+
+	log.Print("Using AWS Account: ", awsAccountID)
+	log.Printf("Using AWS Region: %s", *argAWSRegion)
+	log.Print("Refresh Interval (minutes): ", *argRefreshMinutes)
+
+	kubeClient := newKubeClient()
+	ecrClient := newEcrClient()
+	gcrClient := newGcrClient()
+	acrClient := newAcrClient()
+	c := &controller{
+		kubeClient:    kubeClient,
+		ecrClient:     ecrClient,
+		gcrClient:     gcrClient,
+		acrClient:     acrClient,
+		tokenCache:    map[string]AuthToken{},
+		eventRecorder: newEventRecorder(kubeClient),
+	}
+
+	go serveMetrics()
+
+	// Each secretGenerator's refreshLoop fetches and applies its first
+	// token itself, so nothing else needs to prime the cache here.
+	for _, secretGenerator := range c.secretGenerators() {
+		go c.refreshLoop(secretGenerator)
+	}
+	go c.watchNamespaces()
+
+	select {}
+}
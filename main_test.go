@@ -0,0 +1,61 @@
+/*
+Copyright (c) 2016, UPMC Enterprises
+All rights reserved.
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright
+      notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright
+      notice, this list of conditions and the following disclaimer in the
+      documentation and/or other materials provided with the distribution.
+    * Neither the name UPMC Enterprises nor the
+      names of its contributors may be used to endorse or promote products
+      derived from this software without specific prior written permission.
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL UPMC ENTERPRISES BE LIABLE FOR ANY
+DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextRefreshDelayZeroExpiry(t *testing.T) {
+	*argRefreshMinutes = 42
+
+	got := nextRefreshDelay(time.Time{})
+	want := 42 * time.Minute
+	if got != want {
+		t.Errorf("nextRefreshDelay(zero) = %s, want %s", got, want)
+	}
+}
+
+func TestNextRefreshDelayAlreadyExpired(t *testing.T) {
+	got := nextRefreshDelay(time.Now().Add(-time.Minute))
+	if got != 0 {
+		t.Errorf("nextRefreshDelay(past) = %s, want 0", got)
+	}
+}
+
+func TestNextRefreshDelayWithinJitterBounds(t *testing.T) {
+	const lifetime = time.Hour
+	base := time.Duration(float64(lifetime) * refreshLifetimeFraction)
+	minDelay := time.Duration(float64(base) * (1 - refreshJitterFraction))
+	maxDelay := time.Duration(float64(base) * (1 + refreshJitterFraction))
+
+	for i := 0; i < 20; i++ {
+		delay := nextRefreshDelay(time.Now().Add(lifetime))
+		if delay < minDelay || delay > maxDelay {
+			t.Fatalf("nextRefreshDelay(%s) = %s, want between %s and %s", lifetime, delay, minDelay, maxDelay)
+		}
+	}
+}
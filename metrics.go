@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2016, UPMC Enterprises
+All rights reserved.
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright
+      notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright
+      notice, this list of conditions and the following disclaimer in the
+      documentation and/or other materials provided with the distribution.
+    * Neither the name UPMC Enterprises nor the
+      names of its contributors may be used to endorse or promote products
+      derived from this software without specific prior written permission.
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL UPMC ENTERPRISES BE LIABLE FOR ANY
+DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+var argMetricsAddr = flags.String("metrics-addr", ":9090", `Address to serve Prometheus metrics on`)
+
+var (
+	tokenRefreshTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "registry_creds_token_refresh_total",
+		Help: "Total number of credential refresh attempts, by provider and result.",
+	}, []string{"provider", "result"})
+
+	tokenExpirySeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "registry_creds_token_expiry_seconds",
+		Help: "Unix timestamp, in seconds, at which the current token for a provider expires.",
+	}, []string{"provider"})
+
+	secretSyncTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "registry_creds_secret_sync_total",
+		Help: "Total number of Secret create/update operations, by provider, namespace, and operation.",
+	}, []string{"provider", "namespace", "op"})
+
+	refreshLatencySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "registry_creds_refresh_latency_seconds",
+		Help:    "Latency of provider token refreshes, in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider"})
+)
+
+func init() {
+	prometheus.MustRegister(tokenRefreshTotal, tokenExpirySeconds, secretSyncTotal, refreshLatencySeconds)
+}
+
+// newEventRecorder wires up a record.EventRecorder that writes Events
+// through kubeClient, so SecretSyncFailed/SecretCreated/SecretUpdated
+// events show up in `kubectl describe` for the Secrets/ServiceAccounts this
+// controller manages.
+func newEventRecorder(kubeClient kubeInterface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&unversioned.EventSinkImpl{Interface: kubeClient.Events("")})
+	return broadcaster.NewRecorder(api.EventSource{Component: "registry-creds"})
+}
+
+// recordEvent records a Kubernetes Event against ref if c.eventRecorder was
+// configured; it's a no-op otherwise, so callers don't need to nil-check.
+func (c *controller) recordEvent(ref api.ObjectReference, eventType, reason, message string) {
+	if c.eventRecorder == nil {
+		return
+	}
+	c.eventRecorder.Event(&ref, eventType, reason, message)
+}
+
+// serveMetrics exposes Prometheus metrics on --metrics-addr until the
+// process exits; a failure here is logged but shouldn't take down credential
+// refreshing.
+func serveMetrics() {
+	if *argMetricsAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Printf("Serving metrics on %s", *argMetricsAddr)
+	if err := http.ListenAndServe(*argMetricsAddr, mux); err != nil {
+		log.Printf("Error serving metrics: %v", err)
+	}
+}
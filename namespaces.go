@@ -0,0 +1,145 @@
+/*
+Copyright (c) 2016, UPMC Enterprises
+All rights reserved.
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright
+      notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright
+      notice, this list of conditions and the following disclaimer in the
+      documentation and/or other materials provided with the distribution.
+    * Neither the name UPMC Enterprises nor the
+      names of its contributors may be used to endorse or promote products
+      derived from this software without specific prior written permission.
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL UPMC ENTERPRISES BE LIABLE FOR ANY
+DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+*/
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/util/wait"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+var (
+	argNamespaceLabelSelector = flags.String("namespace-label-selector", "", `Only distribute pull secrets to namespaces matching this label selector (default: all namespaces)`)
+	argExcludedNamespaces     = flags.StringSlice("excluded-namespaces", []string{"kube-system"}, `Namespaces to never distribute pull secrets to`)
+)
+
+// isExcludedNamespace reports whether name is in --excluded-namespaces.
+func isExcludedNamespace(name string) bool {
+	for _, excluded := range *argExcludedNamespaces {
+		if name == excluded {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceListOptions builds the ListOptions used to list/watch namespaces,
+// applying --namespace-label-selector.
+func namespaceListOptions() api.ListOptions {
+	if *argNamespaceLabelSelector == "" {
+		return api.ListOptions{}
+	}
+
+	selector, err := labels.Parse(*argNamespaceLabelSelector)
+	if err != nil {
+		log.Fatalf("invalid --namespace-label-selector %q: %v", *argNamespaceLabelSelector, err)
+	}
+
+	return api.ListOptions{LabelSelector: selector}
+}
+
+// seedNamespace immediately applies every provider's last-known cached
+// AuthToken into a single, newly-observed namespace, so it doesn't have to
+// wait for that provider's next refresh cycle to get a pull secret. Token
+// rotation itself stays on each provider's own refreshLoop.
+func (c *controller) seedNamespace(namespace string) {
+	for _, secretGenerator := range c.secretGenerators() {
+		c.tokenCacheMu.Lock()
+		token, ok := c.tokenCache[secretGenerator.SecretName]
+		c.tokenCacheMu.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		if err := c.applySecretToNamespace(secretGenerator, token, namespace); err != nil {
+			log.Printf("Error seeding secret %s into new namespace %s: %v", secretGenerator.SecretName, namespace, err)
+		}
+	}
+}
+
+// watchNamespaces watches Namespaces and seeds newly-created ones with the
+// current cached pull secrets immediately, instead of waiting for each
+// provider's next refresh (scheduled off its token's expiry, see
+// nextRefreshDelay).
+func (c *controller) watchNamespaces() {
+	lw := &cache.ListWatch{
+		ListFunc: func(options api.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = namespaceListOptions().LabelSelector
+			return c.kubeClient.Namespaces().List(options)
+		},
+		WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = namespaceListOptions().LabelSelector
+			return c.kubeClient.Namespaces().Watch(options)
+		},
+	}
+
+	// Snapshot the namespaces that already exist before the informer starts,
+	// so AddFunc can tell those apart from genuinely new ones by name rather
+	// than by asking the informer whether its initial List has finished
+	// syncing. HasSynced() is unreliable here: client-go's DeltaFIFO.Pop
+	// decrements its initial-population counter before invoking the
+	// callback for the last item in that initial List, so HasSynced() can
+	// report true while AddFunc is still being called for it, causing that
+	// one pre-existing namespace to be seeded as "new" on every restart.
+	existing, err := c.kubeClient.Namespaces().List(namespaceListOptions())
+	if err != nil {
+		log.Printf("Error listing namespaces before starting watch, treating all as new: %v", err)
+		existing = &api.NamespaceList{}
+	}
+	preExisting := make(map[string]bool, len(existing.Items))
+	for _, namespace := range existing.Items {
+		preExisting[namespace.GetName()] = true
+	}
+
+	_, informerController := cache.NewInformer(lw, &api.Namespace{}, 30*time.Minute, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			namespace, ok := obj.(*api.Namespace)
+			if !ok {
+				return
+			}
+			if isExcludedNamespace(namespace.GetName()) {
+				return
+			}
+			if preExisting[namespace.GetName()] {
+				// Already existed in the snapshot above; already seeded at
+				// startup. The informer's own initial List re-delivers it as
+				// an Add, so only treat it as new once.
+				delete(preExisting, namespace.GetName())
+				return
+			}
+			log.Print("New namespace detected, seeding pull secrets: ", namespace.GetName())
+			c.seedNamespace(namespace.GetName())
+		},
+	})
+
+	informerController.Run(wait.NeverStop)
+}
@@ -0,0 +1,55 @@
+/*
+Copyright (c) 2016, UPMC Enterprises
+All rights reserved.
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright
+      notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright
+      notice, this list of conditions and the following disclaimer in the
+      documentation and/or other materials provided with the distribution.
+    * Neither the name UPMC Enterprises nor the
+      names of its contributors may be used to endorse or promote products
+      derived from this software without specific prior written permission.
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL UPMC ENTERPRISES BE LIABLE FOR ANY
+DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+*/
+
+package main
+
+import "testing"
+
+func TestIsExcludedNamespace(t *testing.T) {
+	*argExcludedNamespaces = []string{"kube-system", "kube-public"}
+
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{"kube-system", true},
+		{"kube-public", true},
+		{"default", false},
+		{"", false},
+	}
+
+	for _, c := range cases {
+		if got := isExcludedNamespace(c.name); got != c.want {
+			t.Errorf("isExcludedNamespace(%q) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestIsExcludedNamespaceEmptyList(t *testing.T) {
+	*argExcludedNamespaces = []string{}
+
+	if isExcludedNamespace("kube-system") {
+		t.Error("isExcludedNamespace() = true with an empty exclusion list, want false")
+	}
+}
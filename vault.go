@@ -0,0 +1,246 @@
+/*
+Copyright (c) 2016, UPMC Enterprises
+All rights reserved.
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright
+      notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright
+      notice, this list of conditions and the following disclaimer in the
+      documentation and/or other materials provided with the distribution.
+    * Neither the name UPMC Enterprises nor the
+      names of its contributors may be used to endorse or promote products
+      derived from this software without specific prior written permission.
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL UPMC ENTERPRISES BE LIABLE FOR ANY
+DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	argVaultAddr        = flags.String("vault-addr", "", `Vault/OpenBao address, e.g. https://vault.default:8200; enables the Vault credential provider when set`)
+	argVaultRole        = flags.String("vault-role", "", `Vault role to authenticate as via the Kubernetes auth method`)
+	argVaultAuthPath    = flags.String("vault-auth-path", "kubernetes", `Vault auth mount path for the Kubernetes auth method`)
+	argVaultSecretPath  = flags.String("vault-secret-path", "", `Vault path to read the registry credential from, e.g. secret/data/myregistry`)
+	argVaultSecretName  = flags.String("vault-secret-name", "vault-registry-cred", `Secret name for the Vault-backed registry credential`)
+	argVaultRegistryURL = flags.String("vault-registry-url", "", `Registry endpoint the Vault-issued credential authenticates against, e.g. myharbor.example.com`)
+	argVaultSATokenFile = flags.String("vault-sa-token-file", "/var/run/secrets/kubernetes.io/serviceaccount/token", `Path to the pod's projected Kubernetes ServiceAccount JWT, used to log in to Vault's Kubernetes auth method`)
+)
+
+// vaultProvider lazily builds the single configured vaultProvider, so its
+// lease cache survives across repeated calls to providers().
+func (c *controller) vaultProvider() Provider {
+	c.vaultProviderOnce.Do(func() {
+		c.vaultProviderInst = newVaultProvider()
+	})
+	return c.vaultProviderInst
+}
+
+// defaultVaultLeaseDuration is used when Vault doesn't return a
+// lease_duration for a static (non-dynamic) secret.
+const defaultVaultLeaseDuration = 1 * time.Hour
+
+// vaultProvider is a Provider that reads a registry username/password (or a
+// dynamic, short-lived secret) from HashiCorp Vault or OpenBao, logging in
+// via the Kubernetes auth method with the pod's projected ServiceAccount
+// JWT. It caches the credential and re-reads it at lease_duration/2.
+type vaultProvider struct {
+	httpClient *http.Client
+
+	addr        string
+	role        string
+	authPath    string
+	secretPath  string
+	secretName  string
+	registryURL string
+	saTokenFile string
+
+	mu          sync.Mutex
+	username    string
+	password    string
+	leaseExpiry time.Time
+}
+
+func newVaultProvider() *vaultProvider {
+	return &vaultProvider{
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+		addr:        *argVaultAddr,
+		role:        *argVaultRole,
+		authPath:    *argVaultAuthPath,
+		secretPath:  *argVaultSecretPath,
+		secretName:  *argVaultSecretName,
+		registryURL: *argVaultRegistryURL,
+		saTokenFile: *argVaultSATokenFile,
+	}
+}
+
+func (v *vaultProvider) Name() string { return v.secretName }
+
+func (v *vaultProvider) SecretType() SecretFormat { return SecretFormatDockerConfigJSON }
+
+// Fetch returns the cached registry credential as an AuthToken, reading a
+// fresh one from Vault first if the cached lease is more than half expired.
+func (v *vaultProvider) Fetch(ctx context.Context) (AuthToken, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if time.Now().Before(v.leaseExpiry) {
+		return v.authToken(), nil
+	}
+
+	vaultToken, err := v.login()
+	if err != nil {
+		return AuthToken{}, err
+	}
+
+	username, password, leaseDuration, err := v.readRegistryCredential(vaultToken)
+	if err != nil {
+		return AuthToken{}, err
+	}
+
+	if leaseDuration <= 0 {
+		leaseDuration = defaultVaultLeaseDuration
+	}
+
+	v.username = username
+	v.password = password
+	v.leaseExpiry = time.Now().Add(leaseDuration / 2)
+
+	return v.authToken(), nil
+}
+
+func (v *vaultProvider) authToken() AuthToken {
+	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", v.username, v.password)))
+	return AuthToken{
+		AccessToken: auth,
+		Endpoint:    v.registryURL,
+		ExpiresAt:   v.reportedExpiry(),
+	}
+}
+
+// reportedExpiry pads leaseExpiry out by
+// 1/(refreshLifetimeFraction*(1-refreshJitterFraction)) before handing it to
+// refreshLoop as an AuthToken's ExpiresAt. refreshLoop sleeps
+// refreshLifetimeFraction of the remaining time, +/- refreshJitterFraction,
+// before calling Fetch again; reporting leaseExpiry unpadded would make it
+// wake up before the lease is actually due for renewal, hit the cache above,
+// and recompute an ever-smaller delay against the same unchanged
+// leaseExpiry -- a tightening burst of no-op refreshes as the real renewal
+// approaches. Padding makes refreshLoop's wake-up land no earlier than
+// leaseExpiry, even with the most negative jitter, so Fetch is a genuine
+// cache miss every time it's called.
+func (v *vaultProvider) reportedExpiry() time.Time {
+	remaining := time.Until(v.leaseExpiry)
+	if remaining <= 0 {
+		return v.leaseExpiry
+	}
+	return time.Now().Add(time.Duration(float64(remaining) / (refreshLifetimeFraction * (1 - refreshJitterFraction))))
+}
+
+// login authenticates to Vault's Kubernetes auth method with the pod's
+// projected ServiceAccount JWT and returns a Vault client token.
+func (v *vaultProvider) login() (string, error) {
+	jwt, err := ioutil.ReadFile(v.saTokenFile)
+	if err != nil {
+		return "", err
+	}
+
+	reqBody, err := json.Marshal(map[string]string{
+		"role": v.role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	loginURL := fmt.Sprintf("%s/v1/auth/%s/login", strings.TrimRight(v.addr, "/"), v.authPath)
+	resp, err := v.httpClient.Post(loginURL, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault login failed with status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	return body.Auth.ClientToken, nil
+}
+
+// readRegistryCredential reads the registry credential at v.secretPath,
+// returning its username, password, and lease duration. It understands both
+// a KV v2 response (data.data.*) and a dynamic secret response (data.*).
+func (v *vaultProvider) readRegistryCredential(vaultToken string) (username, password string, leaseDuration time.Duration, err error) {
+	readURL := fmt.Sprintf("%s/v1/%s", strings.TrimRight(v.addr, "/"), strings.TrimLeft(v.secretPath, "/"))
+
+	req, err := http.NewRequest("GET", readURL, nil)
+	if err != nil {
+		return "", "", 0, err
+	}
+	req.Header.Set("X-Vault-Token", vaultToken)
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", 0, fmt.Errorf("vault secret read failed with status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		LeaseDuration int `json:"lease_duration"`
+		Data          struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Data     struct {
+				Username string `json:"username"`
+				Password string `json:"password"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", "", 0, err
+	}
+
+	username, password = body.Data.Username, body.Data.Password
+	if username == "" && password == "" {
+		username, password = body.Data.Data.Username, body.Data.Data.Password
+	}
+	if username == "" || password == "" {
+		return "", "", 0, fmt.Errorf("vault secret at %s has no username/password", v.secretPath)
+	}
+
+	return username, password, time.Duration(body.LeaseDuration) * time.Second, nil
+}
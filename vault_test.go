@@ -0,0 +1,102 @@
+/*
+Copyright (c) 2016, UPMC Enterprises
+All rights reserved.
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright
+      notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright
+      notice, this list of conditions and the following disclaimer in the
+      documentation and/or other materials provided with the distribution.
+    * Neither the name UPMC Enterprises nor the
+      names of its contributors may be used to endorse or promote products
+      derived from this software without specific prior written permission.
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL UPMC ENTERPRISES BE LIABLE FOR ANY
+DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestVaultProvider(addr string) *vaultProvider {
+	return &vaultProvider{
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		addr:       addr,
+		secretPath: "secret/myregistry",
+	}
+}
+
+func TestReadRegistryCredentialKVv1(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"lease_duration": 1800, "data": {"username": "kv1-user", "password": "kv1-pass"}}`))
+	}))
+	defer server.Close()
+
+	v := newTestVaultProvider(server.URL)
+	username, password, leaseDuration, err := v.readRegistryCredential("test-token")
+	if err != nil {
+		t.Fatalf("readRegistryCredential() returned error: %v", err)
+	}
+	if username != "kv1-user" || password != "kv1-pass" {
+		t.Errorf("readRegistryCredential() = (%q, %q), want (%q, %q)", username, password, "kv1-user", "kv1-pass")
+	}
+	if leaseDuration != 1800*time.Second {
+		t.Errorf("readRegistryCredential() leaseDuration = %s, want %s", leaseDuration, 1800*time.Second)
+	}
+}
+
+func TestReadRegistryCredentialKVv2(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"lease_duration": 0, "data": {"data": {"username": "kv2-user", "password": "kv2-pass"}}}`))
+	}))
+	defer server.Close()
+
+	v := newTestVaultProvider(server.URL)
+	username, password, leaseDuration, err := v.readRegistryCredential("test-token")
+	if err != nil {
+		t.Fatalf("readRegistryCredential() returned error: %v", err)
+	}
+	if username != "kv2-user" || password != "kv2-pass" {
+		t.Errorf("readRegistryCredential() = (%q, %q), want (%q, %q)", username, password, "kv2-user", "kv2-pass")
+	}
+	if leaseDuration != 0 {
+		t.Errorf("readRegistryCredential() leaseDuration = %s, want 0", leaseDuration)
+	}
+}
+
+func TestReadRegistryCredentialMissingCredential(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {}}`))
+	}))
+	defer server.Close()
+
+	v := newTestVaultProvider(server.URL)
+	if _, _, _, err := v.readRegistryCredential("test-token"); err == nil {
+		t.Error("readRegistryCredential() = nil error, want an error for a secret with no username/password")
+	}
+}
+
+func TestReadRegistryCredentialNonOKStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	v := newTestVaultProvider(server.URL)
+	if _, _, _, err := v.readRegistryCredential("test-token"); err == nil {
+		t.Error("readRegistryCredential() = nil error, want an error for a non-200 response")
+	}
+}